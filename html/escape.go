@@ -50,6 +50,46 @@ var replacementTable = [...]rune{
 	// 0x0D->'\u000D' is a no-op.
 }
 
+// entityConfig bundles the behavior knobs that unescapeEntity consults
+// in place of the package-level entity, entity2 and entityWide maps,
+// so that callers such as Unescaper can choose a different EntitySet,
+// reject malformed input instead of papering over it, and opt out of
+// the Windows-1252 numeric-reference remapping.
+type entityConfig struct {
+	names     map[string]rune    // primary named-reference table
+	names2    map[string][2]rune // two-codepoint named references, HTML5 only
+	namesWide map[string][2]rune // named references needing extra dst room, HTML5 only
+
+	// legacyPrefixMatch allows matching a prefix of entityName when no
+	// full match is found and the reference isn't terminated by ";",
+	// replicating HTML5's legacy semicolon-less entities. It has no
+	// equivalent in XML or a caller-supplied table.
+	legacyPrefixMatch bool
+
+	// strict rejects unterminated entities and out-of-range numeric
+	// references, reporting them via unescapeEntity's ok result,
+	// rather than silently passing them through or substituting U+FFFD.
+	strict bool
+
+	// disableWindows1252 skips the legacy remapping of numeric
+	// references in the range 0x80-0x9F to their Windows-1252
+	// equivalents, decoding them at face value instead.
+	disableWindows1252 bool
+}
+
+// htmlEntityConfig is the entityConfig used by unescape,
+// UnescapeString and a zero-value Unescaper: the full HTML5 named
+// entity tables, with HTML5's legacy quirks enabled.
+func htmlEntityConfig() entityConfig {
+	populateMapsOnce.Do(populateMaps)
+	return entityConfig{
+		names:             entity,
+		names2:            entity2,
+		namesWide:         entityWide,
+		legacyPrefixMatch: true,
+	}
+}
+
 // unescapeEntity reads an entity like "&lt;" from src[srcPos:] and
 // writes the corresponding "<" to dst[dstPos:], returning dst and the
 // incremented dstPos and srcPos cursors.
@@ -59,8 +99,11 @@ var replacementTable = [...]rune{
 //
 // Precondition: src[srcPos] == '&'.
 //
-// attribute should be true if parsing an attribute value.
-func unescapeEntity[S ~[]byte | string](dst []byte, src S, dstPos, srcPos int, attribute bool) (dst1 []byte, dstPos1, srcPos1 int) {
+// attribute should be true if parsing an attribute value. ok is false
+// if cfg.strict is set and src[srcPos:] was an unterminated or
+// otherwise invalid reference; dst and the cursors are still valid in
+// that case, with the offending bytes passed through unchanged.
+func unescapeEntity[S ~[]byte | string](dst []byte, src S, dstPos, srcPos int, attribute bool, cfg entityConfig) (dst1 []byte, dstPos1, srcPos1 int, ok bool) {
 	var dstIsSrc = len(dst) == len(src)
 
 	// https://html.spec.whatwg.org/multipage/parsing.html#character-reference-state
@@ -72,7 +115,7 @@ func unescapeEntity[S ~[]byte | string](dst []byte, src S, dstPos, srcPos int, a
 	// "&GT", "&LT", "&gt", "&lt", "&#0" ... "&#9"
 	if len(s) < 3 {
 		dst[dstPos] = src[srcPos]
-		return dst, dstPos + 1, srcPos + 1
+		return dst, dstPos + 1, srcPos + 1, !cfg.strict
 	}
 
 	if s[i] == '#' {
@@ -122,18 +165,25 @@ func unescapeEntity[S ~[]byte | string](dst []byte, src S, dstPos, srcPos int, a
 
 		if i < 3 || (hex && i < 4) { // No characters matched.
 			dst[dstPos] = src[srcPos]
-			return dst, dstPos + 1, srcPos + 1
+			return dst, dstPos + 1, srcPos + 1, !cfg.strict
+		}
+
+		invalid := x == 0 || (0xD800 <= x && x <= 0xDFFF) || x > 0x10FFFF
+		if cfg.strict && (invalid || s[i-1] != ';') {
+			dstPos1, srcPos1 = dstPos+i, srcPos+i
+			copy(dst[dstPos:dstPos1], src[srcPos:srcPos1])
+			return dst, dstPos1, srcPos1, false
 		}
 
-		if 0x80 <= x && x <= 0x9F {
+		if 0x80 <= x && x <= 0x9F && !cfg.disableWindows1252 {
 			// Replace characters from Windows-1252 with UTF-8 equivalents.
 			x = replacementTable[x-0x80]
-		} else if x == 0 || (0xD800 <= x && x <= 0xDFFF) || x > 0x10FFFF {
+		} else if invalid {
 			// Replace invalid characters with the replacement character.
 			x = '\uFFFD'
 		}
 
-		return dst, dstPos + utf8.EncodeRune(dst[dstPos:], x), srcPos + i
+		return dst, dstPos + utf8.EncodeRune(dst[dstPos:], x), srcPos + i, true
 	}
 
 	// Consume the maximum number of characters possible, with the
@@ -153,54 +203,84 @@ func unescapeEntity[S ~[]byte | string](dst []byte, src S, dstPos, srcPos int, a
 	}
 
 	entityName := s[1:i]
+	terminated := len(entityName) > 0 && entityName[len(entityName)-1] == ';'
+	legacyPrefixUnterminated := false
 	if len(entityName) == 0 {
 		// No-op.
-	} else if attribute && entityName[len(entityName)-1] != ';' && len(s) > i && s[i] == '=' {
+	} else if attribute && !terminated && len(s) > i && s[i] == '=' {
 		// No-op.
-	} else if x := entity[string(entityName)]; x != 0 {
-		return dst, dstPos + utf8.EncodeRune(dst[dstPos:], x), srcPos + i
-	} else if x := entity2[string(entityName)]; x[0] != 0 {
+	} else if cfg.strict && !terminated {
+		// A direct legacy semicolon-less match (cfg.names holds both
+		// "lt;" and "lt" as separate keys) is still an unterminated
+		// reference; cfg.strict rejects it like any other, falling
+		// through to the passthrough return at the end of this
+		// function.
+	} else if x := cfg.names[string(entityName)]; x != 0 {
+		return dst, dstPos + utf8.EncodeRune(dst[dstPos:], x), srcPos + i, true
+	} else if x := cfg.names2[string(entityName)]; x[0] != 0 {
 		dstPos1 := dstPos + utf8.EncodeRune(dst[dstPos:], x[0])
-		return dst, dstPos1 + utf8.EncodeRune(dst[dstPos1:], x[1]), srcPos + i
-	} else if x := entityWide[string(entityName)]; x[0] != 0 {
+		return dst, dstPos1 + utf8.EncodeRune(dst[dstPos1:], x[1]), srcPos + i, true
+	} else if x := cfg.namesWide[string(entityName)]; x[0] != 0 {
 		// 5 bytes in, 6 bytes out
 		if dstPos == srcPos && dstIsSrc {
 			// make a copy + grow
 			dst = append(dst[:len(dst):len(dst)], 0)
-		}  else if dstPos+6 >= len(dst) {
+		} else if dstPos+6 >= len(dst) {
 			// grow, but don't necessarily make a copy
 			dst = append(dst, 0)
 		}
 		dstPos1 := dstPos + utf8.EncodeRune(dst[dstPos:], x[0])
-		return dst, dstPos1 + utf8.EncodeRune(dst[dstPos1:], x[1]), srcPos + i
-	} else if !attribute {
+		return dst, dstPos1 + utf8.EncodeRune(dst[dstPos1:], x[1]), srcPos + i, true
+	} else if !attribute && cfg.legacyPrefixMatch {
 		maxLen := len(entityName) - 1
 		if maxLen > longestEntityWithoutSemicolon {
 			maxLen = longestEntityWithoutSemicolon
 		}
 		for j := maxLen; j > 1; j-- {
-			if x := entity[string(entityName[:j])]; x != 0 {
-				return dst, dstPos + utf8.EncodeRune(dst[dstPos:], x), srcPos + j + 1
+			if x := cfg.names[string(entityName[:j])]; x != 0 {
+				if cfg.strict {
+					// A legacy prefix match is, by construction,
+					// unterminated at its own boundary (it's only
+					// ever reached by matching a strict prefix of
+					// entityName, never the whole semicolon-terminated
+					// name) regardless of whether entityName itself
+					// happens to end in ';'. cfg.strict rejects it
+					// like any other unterminated reference, falling
+					// through to the passthrough return below.
+					legacyPrefixUnterminated = true
+					break
+				}
+				return dst, dstPos + utf8.EncodeRune(dst[dstPos:], x), srcPos + j + 1, true
 			}
 		}
 	}
 
 	dstPos1, srcPos1 = dstPos+i, srcPos+i
 	copy(dst[dstPos:dstPos1], src[srcPos:srcPos1])
-	return dst, dstPos1, srcPos1
+	return dst, dstPos1, srcPos1, !(cfg.strict && (legacyPrefixUnterminated || len(entityName) > 0 && !terminated))
 }
 
 // unescape unescapes b's entities in-place, so that "a&lt;b" becomes "a<b".
 // attribute should be true if parsing an attribute value.
 func unescape(b []byte, attribute bool) []byte {
 	populateMapsOnce.Do(populateMaps)
+	out, _ := unescapeWithConfig(b, attribute, htmlEntityConfig())
+	return out
+}
+
+// unescapeWithConfig is like unescape but consults cfg instead of the
+// package's default HTML5 entity tables, so that Unescaper can support
+// other EntitySets. ok is false if cfg.strict is set and any entity
+// in b was invalid.
+func unescapeWithConfig(b []byte, attribute bool, cfg entityConfig) (out []byte, ok bool) {
 	i := bytes.IndexByte(b, '&')
 
 	if i < 0 {
-		return b
+		return b, true
 	}
 
-	b1, dst, src := unescapeEntity(b, b, i, i, attribute)
+	b1, dst, src, entOK := unescapeEntity(b, b, i, i, attribute, cfg)
+	ok = entOK
 	for len(b[src:]) > 0 {
 		if b[src] == '&' {
 			i = 0
@@ -215,9 +295,10 @@ func unescape(b []byte, attribute bool) []byte {
 		if i > 0 {
 			copy(b1[dst:], b[src:src+i])
 		}
-		b1, dst, src = unescapeEntity(b1, b, dst+i, src+i, attribute)
+		b1, dst, src, entOK = unescapeEntity(b1, b, dst+i, src+i, attribute, cfg)
+		ok = ok && entOK
 	}
-	return b1[:dst]
+	return b1[:dst], ok
 }
 
 // lower lower-cases the A-Z bytes in b in-place, so that "aBc" becomes "abc".
@@ -349,7 +430,7 @@ func UnescapeString(s string) string {
 	// The +1 is just so that dstIsSrc=false.
 	b := make([]byte, len(s)+1)
 	copy(b, s[:i])
-	b, dst, src := unescapeEntity(b, s, i, i, false)
+	b, dst, src, _ := unescapeEntity(b, s, i, i, false, htmlEntityConfig())
 	for len(s[src:]) > 0 {
 		if s[src] == '&' {
 			i = 0
@@ -364,7 +445,7 @@ func UnescapeString(s string) string {
 		if i > 0 {
 			copy(b[dst:], s[src:src+i])
 		}
-		b, dst, src = unescapeEntity(b, s, dst+i, src+i, false)
+		b, dst, src, _ = unescapeEntity(b, s, dst+i, src+i, false, htmlEntityConfig())
 	}
 	return string(b[:dst])
 }
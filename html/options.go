@@ -0,0 +1,166 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"errors"
+	"strings"
+)
+
+// EntitySet selects which table of named character references an
+// Unescaper or Escaper consults.
+type EntitySet int
+
+const (
+	// EntitySetHTML5 is the full HTML5 named character reference
+	// table, as used by UnescapeString. It is the
+	// default for a zero-value Unescaper or Escaper.
+	EntitySetHTML5 EntitySet = iota
+
+	// EntitySetHTML4 is the smaller table of named character
+	// references defined by the HTML 4.01 and XHTML 1.0 DTDs. Use it
+	// when producing or consuming documents that must stay valid
+	// against those older DTDs.
+	EntitySetHTML4
+
+	// EntitySetXML recognizes and produces only the five named
+	// references defined by XML itself: &lt;, &gt;, &amp;, &apos; and
+	// &quot;. Use it for XML and XHTML fragments, which don't define
+	// the rest of the HTML tables.
+	EntitySetXML
+)
+
+// ErrInvalidEntity is the error reported by a strict Unescaper when it
+// encounters an unterminated or otherwise invalid character reference.
+var ErrInvalidEntity = errors.New("html: invalid character reference")
+
+// entityXML is the named-reference table for EntitySetXML.
+var entityXML = map[string]rune{
+	"lt;":   '<',
+	"gt;":   '>',
+	"amp;":  '&',
+	"apos;": '\'',
+	"quot;": '"',
+}
+
+// entityHTML4 is the named-reference table for EntitySetHTML4: the
+// Latin-1, Symbol and Special entity sets defined by the HTML 4.01 and
+// XHTML 1.0 DTDs. Unlike the HTML5 table, none of these decode to more
+// than one code point, so a single map[string]rune is enough.
+var entityHTML4 = map[string]rune{
+	// Latin-1 (ISO 8859-1 codepoints 160-255).
+	"nbsp;": ' ', "iexcl;": '¡', "cent;": '¢', "pound;": '£',
+	"curren;": '¤', "yen;": '¥', "brvbar;": '¦', "sect;": '§',
+	"uml;": '¨', "copy;": '©', "ordf;": 'ª', "laquo;": '«',
+	"not;": '¬', "shy;": '­', "reg;": '®', "macr;": '¯',
+	"deg;": '°', "plusmn;": '±', "sup2;": '²', "sup3;": '³',
+	"acute;": '´', "micro;": 'µ', "para;": '¶', "middot;": '·',
+	"cedil;": '¸', "sup1;": '¹', "ordm;": 'º', "raquo;": '»',
+	"frac14;": '¼', "frac12;": '½', "frac34;": '¾', "iquest;": '¿',
+	"Agrave;": 'À', "Aacute;": 'Á', "Acirc;": 'Â', "Atilde;": 'Ã',
+	"Auml;": 'Ä', "Aring;": 'Å', "AElig;": 'Æ', "Ccedil;": 'Ç',
+	"Egrave;": 'È', "Eacute;": 'É', "Ecirc;": 'Ê', "Euml;": 'Ë',
+	"Igrave;": 'Ì', "Iacute;": 'Í', "Icirc;": 'Î', "Iuml;": 'Ï',
+	"ETH;": 'Ð', "Ntilde;": 'Ñ', "Ograve;": 'Ò', "Oacute;": 'Ó',
+	"Ocirc;": 'Ô', "Otilde;": 'Õ', "Ouml;": 'Ö', "times;": '×',
+	"Oslash;": 'Ø', "Ugrave;": 'Ù', "Uacute;": 'Ú', "Ucirc;": 'Û',
+	"Uuml;": 'Ü', "Yacute;": 'Ý', "THORN;": 'Þ', "szlig;": 'ß',
+	"agrave;": 'à', "aacute;": 'á', "acirc;": 'â', "atilde;": 'ã',
+	"auml;": 'ä', "aring;": 'å', "aelig;": 'æ', "ccedil;": 'ç',
+	"egrave;": 'è', "eacute;": 'é', "ecirc;": 'ê', "euml;": 'ë',
+	"igrave;": 'ì', "iacute;": 'í', "icirc;": 'î', "iuml;": 'ï',
+	"eth;": 'ð', "ntilde;": 'ñ', "ograve;": 'ò', "oacute;": 'ó',
+	"ocirc;": 'ô', "otilde;": 'õ', "ouml;": 'ö', "divide;": '÷',
+	"oslash;": 'ø', "ugrave;": 'ù', "uacute;": 'ú', "ucirc;": 'û',
+	"uuml;": 'ü', "yacute;": 'ý', "thorn;": 'þ', "yuml;": 'ÿ',
+
+	// Symbols, Greek letters and mathematical operators.
+	"fnof;": 'ƒ', "Alpha;": 'Α', "Beta;": 'Β', "Gamma;": 'Γ',
+	"Delta;": 'Δ', "Epsilon;": 'Ε', "Zeta;": 'Ζ', "Eta;": 'Η',
+	"Theta;": 'Θ', "Iota;": 'Ι', "Kappa;": 'Κ', "Lambda;": 'Λ',
+	"Mu;": 'Μ', "Nu;": 'Ν', "Xi;": 'Ξ', "Omicron;": 'Ο',
+	"Pi;": 'Π', "Rho;": 'Ρ', "Sigma;": 'Σ', "Tau;": 'Τ',
+	"Upsilon;": 'Υ', "Phi;": 'Φ', "Chi;": 'Χ', "Psi;": 'Ψ',
+	"Omega;": 'Ω', "alpha;": 'α', "beta;": 'β', "gamma;": 'γ',
+	"delta;": 'δ', "epsilon;": 'ε', "zeta;": 'ζ', "eta;": 'η',
+	"theta;": 'θ', "iota;": 'ι', "kappa;": 'κ', "lambda;": 'λ',
+	"mu;": 'μ', "nu;": 'ν', "xi;": 'ξ', "omicron;": 'ο',
+	"pi;": 'π', "rho;": 'ρ', "sigmaf;": 'ς', "sigma;": 'σ',
+	"tau;": 'τ', "upsilon;": 'υ', "phi;": 'φ', "chi;": 'χ',
+	"psi;": 'ψ', "omega;": 'ω', "thetasym;": 'ϑ', "upsih;": 'ϒ',
+	"piv;": 'ϖ', "bull;": '•', "hellip;": '…', "prime;": '′',
+	"Prime;": '″', "oline;": '‾', "frasl;": '⁄', "weierp;": '℘',
+	"image;": 'ℑ', "real;": 'ℜ', "trade;": '™', "alefsym;": 'ℵ',
+	"larr;": '←', "uarr;": '↑', "rarr;": '→', "darr;": '↓',
+	"harr;": '↔', "crarr;": '↵', "lArr;": '⇐', "uArr;": '⇑',
+	"rArr;": '⇒', "dArr;": '⇓', "hArr;": '⇔', "forall;": '∀',
+	"part;": '∂', "exist;": '∃', "empty;": '∅', "nabla;": '∇',
+	"isin;": '∈', "notin;": '∉', "ni;": '∋', "prod;": '∏',
+	"sum;": '∑', "minus;": '−', "lowast;": '∗', "radic;": '√',
+	"prop;": '∝', "infin;": '∞', "ang;": '∠', "and;": '∧',
+	"or;": '∨', "cap;": '∩', "cup;": '∪', "int;": '∫',
+	"there4;": '∴', "sim;": '∼', "cong;": '≅', "asymp;": '≈',
+	"ne;": '≠', "equiv;": '≡', "le;": '≤', "ge;": '≥',
+	"sub;": '⊂', "sup;": '⊃', "nsub;": '⊄', "sube;": '⊆',
+	"supe;": '⊇', "oplus;": '⊕', "otimes;": '⊗', "perp;": '⊥',
+	"sdot;": '⋅', "lceil;": '⌈', "rceil;": '⌉', "lfloor;": '⌊',
+	"rfloor;": '⌋', "lang;": '〈', "rang;": '〉', "loz;": '◊',
+	"spades;": '♠', "clubs;": '♣', "hearts;": '♥', "diams;": '♦',
+
+	// Special (markup-significant and internationalization characters).
+	"quot;": '"', "amp;": '&', "lt;": '<', "gt;": '>',
+	"OElig;": 'Œ', "oelig;": 'œ', "Scaron;": 'Š', "scaron;": 'š',
+	"Yuml;": 'Ÿ', "circ;": 'ˆ', "tilde;": '˜', "ensp;": ' ',
+	"emsp;": ' ', "thinsp;": ' ', "zwnj;": '‌', "zwj;": '‍',
+	"lrm;": '‎', "rlm;": '‏', "ndash;": '–', "mdash;": '—',
+	"lsquo;": '‘', "rsquo;": '’', "sbquo;": '‚', "ldquo;": '“',
+	"rdquo;": '”', "bdquo;": '„', "dagger;": '†', "Dagger;": '‡',
+	"permil;": '‰', "lsaquo;": '‹', "rsaquo;": '›', "euro;": '€',
+}
+
+// htmlEscaperXML is like htmlEscaper but spells the apostrophe
+// reference the canonical XML way, "&apos;", rather than the shorter
+// "&#39;" that htmlEscaper prefers for ordinary HTML.
+var htmlEscaperXML = strings.NewReplacer(
+	`&`, "&amp;",
+	`'`, "&apos;",
+	`<`, "&lt;",
+	`>`, "&gt;",
+	`"`, "&quot;",
+	"\r", "&#13;",
+)
+
+// Escaper is a configurable counterpart to EscapeString. The zero
+// Escaper behaves exactly like EscapeString; set Entities to
+// EntitySetXML to produce markup that's safe to re-parse as XML,
+// where "&#39;" and "&#34;" aren't guaranteed shorthands for
+// apostrophe and quote.
+type Escaper struct {
+	Entities EntitySet
+}
+
+// String escapes special characters in s, the way EscapeString does,
+// using e's configured EntitySet to choose how apostrophes and double
+// quotes are spelled.
+func (e Escaper) String(s string) string {
+	if e.Entities == EntitySetXML {
+		return htmlEscaperXML.Replace(s)
+	}
+	return htmlEscaper.Replace(s)
+}
+
+// table returns the entityConfig for set. EntitySetHTML4 and
+// EntitySetXML need no lazy population, unlike EntitySetHTML5, whose
+// tables htmlEntityConfig populates on first use.
+func (set EntitySet) table() entityConfig {
+	switch set {
+	case EntitySetHTML4:
+		return entityConfig{names: entityHTML4}
+	case EntitySetXML:
+		return entityConfig{names: entityXML}
+	default:
+		return htmlEntityConfig()
+	}
+}
@@ -0,0 +1,472 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"bufio"
+	"io"
+
+	"golang.org/x/text/transform"
+)
+
+// holdBack is how many trailing bytes of buffered, unprocessed input
+// might still be the prefix of a longer entity reference, and so must
+// be held back until more input arrives, or EOF makes clear that no
+// more is coming. "&CounterClockwiseContourIntegral;" is the longest
+// named reference in the HTML5 table, and comfortably covers ordinary
+// numeric references like "&#x10FFFF;" too.
+//
+// Numeric references have no such bound, though - unescapeEntity
+// consumes as many digits as are there, however many that is - so a
+// pending one is tracked separately by pendingNumericRef rather than
+// folded into this constant.
+const holdBack = len("&CounterClockwiseContourIntegral;")
+
+// pendingNumericRef reports whether s is the start of a "&#" or "&#x"
+// numeric character reference whose digit run reaches the end of s
+// without hitting a terminating ";" or non-digit byte, meaning more of
+// the reference may still be on its way and s shouldn't be decoded yet.
+func pendingNumericRef(s []byte) bool {
+	if len(s) < 2 || s[0] != '&' || s[1] != '#' {
+		return false
+	}
+	i := 2
+	if i < len(s) && (s[i] == 'x' || s[i] == 'X') {
+		i++
+	}
+	for ; i < len(s); i++ {
+		c := s[i]
+		if !(('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')) {
+			return false // terminated, one way or another
+		}
+	}
+	return true
+}
+
+// pendingNumericRefStart scans backward from the end of buf for a
+// numeric character reference whose digit run is still accumulating -
+// see pendingNumericRef - and returns its start offset. It returns
+// -1 if buf's tail isn't such a reference.
+func pendingNumericRefStart(buf []byte) int {
+	i := len(buf)
+	for i > 0 {
+		c := buf[i-1]
+		if !(('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')) {
+			break
+		}
+		i--
+	}
+	for _, start := range [2]int{i - 3, i - 2} {
+		if start >= 0 && pendingNumericRef(buf[start:]) {
+			return start
+		}
+	}
+	return -1
+}
+
+// entityNeedsMoreData reports whether the entity reference starting at
+// s[0] ('&') might still be incomplete - either because s isn't yet
+// long enough to rule out a longer reference still arriving, or
+// because it's an open-ended numeric reference - and so shouldn't be
+// decoded until more input is available. It's the shared rule behind
+// both Unescaper.safeLen and Transform's per-entity ErrShortSrc guard.
+func entityNeedsMoreData(s []byte) bool {
+	return len(s) <= holdBack || pendingNumericRef(s)
+}
+
+// Unescaper is an io.Reader that unescapes HTML read from the wrapped
+// reader, e.g. turning "a&lt;b" into "a<b". By default it unescapes
+// the same range of entities as UnescapeString, and correctly decodes
+// entity references that are split across the wrapped reader's Read
+// calls.
+//
+// Unescaper also implements transform.Transformer, so its decoding can
+// be composed with encoders from golang.org/x/text via transform.Chain
+// instead of being used as an io.Reader.
+type Unescaper struct {
+	// Attribute selects attribute-value parsing rules, matching the
+	// attribute argument to the package's entity parsing: a "&foo"
+	// reference immediately followed by "=" is left unexpanded.
+	Attribute bool
+
+	// Entities selects the named character reference table to consult.
+	// It is ignored if CustomEntities is non-nil. The zero value is
+	// EntitySetHTML5.
+	Entities EntitySet
+
+	// CustomEntities, if non-nil, replaces the table selected by
+	// Entities with a caller-supplied map from entity name (including
+	// the trailing ";") to the rune it decodes to.
+	CustomEntities map[string]rune
+
+	// Strict rejects unterminated entities and out-of-range numeric
+	// references by returning ErrInvalidEntity from Read or Transform,
+	// instead of silently passing them through or substituting U+FFFD.
+	// The offending bytes are still copied to the output unchanged.
+	Strict bool
+
+	// DisableWindows1252 turns off the legacy remapping of numeric
+	// references in the range 0x80-0x9F to their Windows-1252
+	// equivalents, so they decode at face value instead.
+	DisableWindows1252 bool
+
+	r   io.Reader
+	buf []byte // bytes read from r that have not yet been unescaped
+	out []byte // unescaped bytes not yet returned to the caller
+	err error  // error from r, or ErrInvalidEntity, reported once buf and out are drained
+}
+
+// NewUnescaper returns an io.Reader that reads from r, unescaping HTML
+// entities as it goes.
+func NewUnescaper(r io.Reader) *Unescaper {
+	return &Unescaper{r: r}
+}
+
+// config builds the entityConfig that u's fields select.
+func (u *Unescaper) config() entityConfig {
+	cfg := u.Entities.table()
+	if u.CustomEntities != nil {
+		cfg = entityConfig{names: u.CustomEntities}
+	}
+	cfg.strict = u.Strict
+	cfg.disableWindows1252 = u.DisableWindows1252
+	return cfg
+}
+
+func (u *Unescaper) Read(p []byte) (n int, err error) {
+	for len(u.out) == 0 {
+		safe := u.safeLen()
+		if safe <= 0 && u.err == nil {
+			var tmp [4096]byte
+			rn, rerr := u.r.Read(tmp[:])
+			u.buf = append(u.buf, tmp[:rn]...)
+			u.err = rerr
+			continue
+		}
+		if safe <= 0 {
+			return 0, u.err
+		}
+
+		var ok bool
+		u.out, ok = unescapeWithConfig(append([]byte(nil), u.buf[:safe]...), u.Attribute, u.config())
+		u.buf = u.buf[safe:]
+		if !ok && u.err == nil {
+			// Don't clobber a real error from r with ErrInvalidEntity;
+			// the genuine error takes priority once out and buf drain.
+			u.err = ErrInvalidEntity
+		}
+	}
+
+	n = copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+// safeLen returns how many leading bytes of u.buf are safe to unescape
+// right now: all of it, once u.r is exhausted, or everything before
+// the first '&' that can't yet be decoded.
+//
+// Two things can disqualify a '&': entityNeedsMoreData - the same rule
+// Transform uses - says its reference might still be growing because
+// not enough of u.buf follows it yet, or its reference, however much
+// of u.buf is already in hand, simply extends past the candidate cut.
+// In attribute mode, a reference that ends right at a cut followed by
+// "=" is also held back, since that "=" decides whether it expands.
+// The latter check covers every '&' before the cut, not just those in
+// the held-back tail: a '&' well before the naive holdBack-sized cut
+// can still own a reference that reaches past it (entity names run up
+// to holdBack bytes, and numeric ones further still).
+func (u *Unescaper) safeLen() int {
+	if u.err != nil {
+		return len(u.buf)
+	}
+	safe := len(u.buf) - holdBack
+	if safe < 0 {
+		safe = 0
+	}
+	for i := safe; i < len(u.buf); i++ {
+		if u.buf[i] == '&' && entityNeedsMoreData(u.buf[i:]) {
+			safe = i
+			break
+		}
+	}
+	for i := safe - 1; i >= 0; i-- {
+		if u.buf[i] != '&' {
+			continue
+		}
+		if end := i + entityRefLen(u.buf[i:]); end > safe || (u.Attribute && end == safe && u.buf[safe] == '=') {
+			safe = i
+		}
+	}
+	if start := pendingNumericRefStart(u.buf[:safe]); start >= 0 {
+		safe = start
+	}
+	return safe
+}
+
+// Transform implements transform.Transformer.
+func (u *Unescaper) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	cfg := u.config()
+	for nSrc < len(src) {
+		if src[nSrc] != '&' {
+			if nDst == len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = src[nSrc]
+			nDst++
+			nSrc++
+			continue
+		}
+		if !atEOF && entityNeedsMoreData(src[nSrc:]) {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+
+		decoded, consumed, ok := unescapeOne(src[nSrc:], u.Attribute, cfg)
+		if len(dst)-nDst < len(decoded) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], decoded)
+		nSrc += consumed
+		if !ok {
+			return nDst, nSrc, ErrInvalidEntity
+		}
+	}
+	return nDst, nSrc, nil
+}
+
+// Reset implements transform.Transformer.
+func (u *Unescaper) Reset() {}
+
+// unescapeOne decodes a single entity at the start of s (s[0] == '&'),
+// returning the decoded bytes and the number of bytes of s it consumed.
+func unescapeOne(s []byte, attribute bool, cfg entityConfig) (decoded []byte, consumed int, ok bool) {
+	// Bound s to just the one reference being decoded, plus one byte of
+	// lookahead for the attribute "=" check below, rather than the
+	// entire remaining chunk: Transform may be called with a large,
+	// mostly-unrelated src tail following the '&', and every entity in
+	// it would otherwise pay for a fresh chunk-sized allocation.
+	n := entityRefLen(s) + 1
+	if n < 3 {
+		// unescapeEntity treats anything shorter than 3 bytes as too
+		// short to be an entity at all, regardless of what follows;
+		// don't truncate s below that or we'd trip that shortcut
+		// ourselves and return a different ok than the untruncated
+		// call would have.
+		n = 3
+	}
+	if n < len(s) {
+		s = s[:n]
+	}
+
+	// In cfg.strict mode, an invalid reference is copied through
+	// verbatim rather than decoded, so buf must be able to hold all of
+	// s, not just a decoded rune; the +1 mirrors UnescapeString's, so
+	// that unescapeEntity doesn't take buf to be s itself (dstIsSrc).
+	buf := make([]byte, len(s)+1)
+	dst, dstPos, srcPos, ok := unescapeEntity(buf, s, 0, 0, attribute, cfg)
+	return dst[:dstPos], srcPos, ok
+}
+
+// entityRefLen returns the length of the entity reference token starting
+// at s[0] ('&'): "#" or "#x"/"#X" followed by digits for a numeric
+// reference, or a run of letters and digits for a named one, plus a
+// trailing ";" if present. It does not validate the reference - just
+// bounds it, so callers can size scratch space to the one reference
+// rather than to all of s.
+func entityRefLen(s []byte) int {
+	i := 1
+	if i < len(s) && s[i] == '#' {
+		i++
+		if i < len(s) && (s[i] == 'x' || s[i] == 'X') {
+			i++
+		}
+		for i < len(s) && isHexDigit(s[i]) {
+			i++
+		}
+	} else {
+		for i < len(s) && isEntityNameByte(s[i]) {
+			i++
+		}
+	}
+	if i < len(s) && s[i] == ';' {
+		i++
+	}
+	return i
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isEntityNameByte(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// writerCloser adapts an io.Writer to the package's writer interface,
+// buffering through a bufio.Writer when w doesn't already implement
+// it, and flushing that buffer on Close.
+type writerCloser struct {
+	w  io.Writer
+	bw *bufio.Writer
+}
+
+func (c *writerCloser) out() writer {
+	if ww, ok := c.w.(writer); ok {
+		return ww
+	}
+	if c.bw == nil {
+		c.bw = bufio.NewWriter(c.w)
+	}
+	return c.bw
+}
+
+// Close flushes any buffered output to the wrapped writer. It does not
+// close the wrapped writer.
+func (c *writerCloser) Close() error {
+	if c.bw != nil {
+		return c.bw.Flush()
+	}
+	return nil
+}
+
+// EscapeWriter is an io.WriteCloser that HTML-escapes bytes written to
+// it before forwarding them to the wrapped writer, e.g. turning "<"
+// into "&lt;". It escapes the same characters as EscapeString.
+//
+// EscapeWriter also implements transform.Transformer, so its escaping
+// can be composed with encoders from golang.org/x/text.
+type EscapeWriter struct {
+	writerCloser
+}
+
+// NewEscaper returns an io.WriteCloser that HTML-escapes bytes written
+// to it before writing them to w. Callers must Close it to flush any
+// output buffered on w's behalf.
+func NewEscaper(w io.Writer) *EscapeWriter {
+	return &EscapeWriter{writerCloser{w: w}}
+}
+
+func (e *EscapeWriter) Write(p []byte) (int, error) {
+	if err := escape(e.out(), string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Transform implements transform.Transformer.
+func (e *EscapeWriter) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		var escaped string
+		switch src[nSrc] {
+		case '&':
+			escaped = "&amp;"
+		case '\'':
+			escaped = "&#39;"
+		case '<':
+			escaped = "&lt;"
+		case '>':
+			escaped = "&gt;"
+		case '"':
+			escaped = "&#34;"
+		case '\r':
+			escaped = "&#13;"
+		default:
+			if nDst == len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = src[nSrc]
+			nDst++
+			nSrc++
+			continue
+		}
+		if len(dst)-nDst < len(escaped) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], escaped)
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+// Reset implements transform.Transformer.
+func (e *EscapeWriter) Reset() {}
+
+// CommentEscapeWriter is like EscapeWriter but escapes its input the
+// way escapeComment does: less aggressively, so that HTML comments
+// whose contents are meaningful (see escapeComment) aren't mangled.
+type CommentEscapeWriter struct {
+	writerCloser
+	last  byte // last byte written, for escapeComment's look-behind
+	wrote bool // whether last is valid, i.e. whether anything has been written yet
+}
+
+// NewCommentEscaper returns an io.WriteCloser that escapes bytes
+// written to it the way escapeComment does, before writing them to w.
+// Callers must Close it to flush any output buffered on w's behalf.
+func NewCommentEscaper(w io.Writer) *CommentEscapeWriter {
+	return &CommentEscapeWriter{writerCloser: writerCloser{w: w}}
+}
+
+func (c *CommentEscapeWriter) Write(p []byte) (int, error) {
+	out := c.out()
+	for i, b := range []byte(p) {
+		var escaped string
+		switch b {
+		case '&':
+			escaped = "&amp;"
+		case '>':
+			if c.wrote && c.last != '!' && c.last != '-' {
+				break
+			}
+			escaped = "&gt;"
+		}
+		if escaped != "" {
+			if _, err := out.WriteString(escaped); err != nil {
+				return i, err
+			}
+		} else if err := out.WriteByte(b); err != nil {
+			return i, err
+		}
+		c.last, c.wrote = b, true
+	}
+	return len(p), nil
+}
+
+// Transform implements transform.Transformer.
+func (c *CommentEscapeWriter) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		b := src[nSrc]
+		var escaped string
+		switch b {
+		case '&':
+			escaped = "&amp;"
+		case '>':
+			if c.wrote && c.last != '!' && c.last != '-' {
+				break
+			}
+			escaped = "&gt;"
+		}
+		if escaped == "" {
+			if nDst == len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = b
+			nDst++
+		} else {
+			if len(dst)-nDst < len(escaped) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			nDst += copy(dst[nDst:], escaped)
+		}
+		c.last, c.wrote = b, true
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+// Reset implements transform.Transformer.
+func (c *CommentEscapeWriter) Reset() {
+	c.last, c.wrote = 0, false
+}
@@ -0,0 +1,258 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/transform"
+)
+
+// chunkReader is an io.Reader that returns s in the given chunk sizes,
+// one Read call per chunk, so a test can pin down exactly where a
+// Read's input is split.
+type chunkReader struct {
+	s      string
+	chunks []int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	if n > len(r.s) {
+		n = len(r.s)
+	}
+	n = copy(p, r.s[:n])
+	r.s = r.s[n:]
+	return n, nil
+}
+
+// readAllChunks feeds s through a Unescaper in the given chunk sizes
+// and returns everything it reads.
+func readAllChunks(t *testing.T, u *Unescaper, s string, chunks []int) (string, error) {
+	t.Helper()
+	u.r = &chunkReader{s: s, chunks: chunks}
+	u.buf, u.out, u.err = nil, nil, nil
+	got, err := io.ReadAll(u)
+	return string(got), err
+}
+
+func TestUnescaperSplitRead(t *testing.T) {
+	longNum := "&#" + strings.Repeat("1", 30) + ";TAIL"
+
+	tests := []struct {
+		name   string
+		src    string
+		chunks []int
+	}{
+		{"no split", "a&lt;b&amp;c", []int{100}},
+		{"split mid named entity", "a&lt;b&amp;c", []int{2, 100}},
+		{"split right after ampersand", "a&lt;b&amp;c", []int{1, 100}},
+		{"split inside legacy semicolon-less entity", "&notin X", []int{4, 100}},
+		{"split inside long numeric reference", longNum, []int{10, 100}},
+		{"split at every offset of long numeric reference", longNum, []int{1, 1, 1, 1, 1, 100}},
+	}
+
+	// Inputs well over holdBack bytes, delivered in a single Read, with
+	// a named or numeric entity walked across every offset from the
+	// end of the buffer. This exercises the partial-decode path - a
+	// Read returning before EOF arrives - rather than always deferring
+	// decoding to a final, fully-buffered Read, and it covers the
+	// boundary a fixed holdBack-sized cut used to slice through: an
+	// entity sitting in, not just before, the held-back tail.
+	pad := strings.Repeat("p", 40)
+	for trail := 0; trail <= holdBack+5; trail++ {
+		tail := strings.Repeat("q", trail)
+		tests = append(tests,
+			struct {
+				name   string
+				src    string
+				chunks []int
+			}{"named entity, trail=" + strconv.Itoa(trail), pad + "a&amp;bbb" + tail, []int{1 << 20}},
+			struct {
+				name   string
+				src    string
+				chunks []int
+			}{"numeric entity, trail=" + strconv.Itoa(trail), pad + "a&#65;bbb" + tail, []int{1 << 20}},
+		)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := UnescapeString(tt.src)
+			u := NewUnescaper(nil)
+			got, err := readAllChunks(t, u, tt.src, tt.chunks)
+			if err != nil {
+				t.Fatalf("readAllChunks(%q) error: %v", tt.src, err)
+			}
+			if got != want {
+				t.Errorf("readAllChunks(%q, chunks=%v) = %q, want %q (UnescapeString)", tt.src, tt.chunks, got, want)
+			}
+		})
+	}
+}
+
+func TestUnescaperStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{"well-formed named entity", "a&lt;b", false},
+		{"well-formed numeric entity", "a&#65;b", false},
+		{"unterminated named entity", "a&lt b", true},
+		{"out-of-range numeric entity", "a&#x110000;b", true},
+		{"unterminated legacy prefix match inside a longer terminated name", "a&ampfoo;b", true},
+		{"plain text", "hello", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Unescaper{Strict: true}
+			dst := make([]byte, len(tt.src)+16)
+			_, _, terr := u.Transform(dst, []byte(tt.src), true)
+			if gotErr := terr != nil; gotErr != tt.wantErr {
+				t.Errorf("Strict Transform(%q) error = %v, wantErr %v", tt.src, terr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnescaperCustomEntities(t *testing.T) {
+	u := &Unescaper{CustomEntities: map[string]rune{"foo;": 'F', "bar;": 'B'}}
+	src := "&foo;&bar;&lt;"
+	// &lt; isn't in the custom table, so it passes through unchanged.
+	want := "FB&lt;"
+
+	dst := make([]byte, 64)
+	nDst, nSrc, err := u.Transform(dst, []byte(src), true)
+	if err != nil {
+		t.Fatalf("Transform error: %v", err)
+	}
+	if nSrc != len(src) {
+		t.Errorf("consumed %d bytes, want %d", nSrc, len(src))
+	}
+	if got := string(dst[:nDst]); got != want {
+		t.Errorf("Transform(%q) = %q, want %q", src, got, want)
+	}
+}
+
+func TestUnescaperEntitySets(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     EntitySet
+		escaped string
+		want    string
+	}{
+		{"HTML4 latin-1 entity", EntitySetHTML4, "caf&eacute;", "café"},
+		{"HTML4 leaves HTML5-only entity alone", EntitySetHTML4, "a&aopf;b", "a&aopf;b"},
+		{"XML named entities", EntitySetXML, "&lt;a&amp;b&gt;", "<a&b>"},
+		{"XML leaves HTML entity alone", EntitySetXML, "&eacute;", "&eacute;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Unescaper{Entities: tt.set}
+			got, err := io.ReadAll(transform.NewReader(strings.NewReader(tt.escaped), u))
+			if err != nil {
+				t.Fatalf("ReadAll error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Unescaper{Entities: %v}.Read(%q) = %q, want %q", tt.set, tt.escaped, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscaperEntitySetXML(t *testing.T) {
+	e := Escaper{Entities: EntitySetXML}
+	got := e.String(`a'b"c`)
+	want := "a&apos;b&quot;c"
+	if got != want {
+		t.Errorf("Escaper{Entities: EntitySetXML}.String(%q) = %q, want %q", `a'b"c`, got, want)
+	}
+}
+
+func TestEscapeWriter(t *testing.T) {
+	tests := []string{
+		`<a href="foo">bar</a> & 'quote'`,
+		"plain text",
+		"",
+	}
+	for _, s := range tests {
+		var buf bytes.Buffer
+		w := NewEscaper(&buf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatalf("Write(%q) error: %v", s, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close error: %v", err)
+		}
+		if got, want := buf.String(), EscapeString(s); got != want {
+			t.Errorf("EscapeWriter.Write(%q) produced %q, want %q (EscapeString)", s, got, want)
+		}
+	}
+}
+
+func TestCommentEscapeWriter(t *testing.T) {
+	tests := []string{
+		"<!--[if IE]>hi<![endif]-->",
+		"a & b > c",
+		"",
+	}
+	for _, s := range tests {
+		var buf bytes.Buffer
+		w := NewCommentEscaper(&buf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatalf("Write(%q) error: %v", s, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close error: %v", err)
+		}
+		if got, want := buf.String(), escapeCommentString(s); got != want {
+			t.Errorf("CommentEscapeWriter.Write(%q) produced %q, want %q (escapeCommentString)", s, got, want)
+		}
+	}
+}
+
+func TestPendingNumericRefStart(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"room#1234", -1},
+		{"price#x1A2B", -1},
+		{"&#1234", 0},
+		{"a&#x1A2B", 1},
+	}
+	for _, tt := range tests {
+		if got := pendingNumericRefStart([]byte(tt.s)); got != tt.want {
+			t.Errorf("pendingNumericRefStart(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEntityRefLen(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"&lt;rest", 4},
+		{"&notin rest", 6},
+		{"&#65;rest", 5},
+		{"&#x41;rest", 6},
+		{"&#" + strings.Repeat("1", 10) + ";x", 13},
+		{"&!not an entity", 1},
+	}
+	for _, tt := range tests {
+		if got := entityRefLen([]byte(tt.s)); got != tt.want {
+			t.Errorf("entityRefLen(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}